@@ -16,6 +16,13 @@ import (
 	"bytes"
 	"io"
 	"regexp"
+	"sync"
+	"crypto/rand"
+	"encoding/hex"
+	"math/big"
+	"strconv"
+	"encoding/json"
+	"sort"
 )
 
 var config struct {
@@ -25,10 +32,27 @@ var config struct {
 	BotToken             string        `long:"tg-token" env:"TG_TOKEN" description:"Telegram BOT API token for notifications"`
 	BotChatID            int64         `long:"tg-chat-id" env:"TG_CHAT_ID" description:"Telegram chat ID"`
 	ThrottleNotification time.Duration `long:"notification-interval" env:"NOTIFICATION_INTERVAL" description:"Merge notifications to one message during this time" default:"1m"`
+	AuthEnabled          bool          `long:"auth-enabled" env:"AUTH_ENABLED" description:"Require a bearer token on /translate and /subscribe routes"`
+	TgAllowedUsers       []int64       `long:"tg-allowed-users" env:"TG_ALLOWED_USERS" description:"Telegram user IDs allowed to use the bot (unset allows everyone)"`
+	LanguagesCacheTTL    time.Duration `long:"languages-cache-ttl" env:"LANGUAGES_CACHE_TTL" description:"How long to cache each engine's supported language list" default:"24h"`
 }
 
 var engines = []string{"google"} // default, will be overwritten
-var notifyChannel chan string
+
+type notification struct {
+	ChatID  int64 // 0 means the default admin chat
+	Message string
+}
+
+var notifyChannel chan notification
+
+func notify(message string) {
+	notifyChannel <- notification{Message: message}
+}
+
+func notifyChat(chatID int64, message string) {
+	notifyChannel <- notification{ChatID: chatID, Message: message}
+}
 
 func main() {
 	_, err := flags.Parse(&config)
@@ -41,7 +65,58 @@ func main() {
 	}
 	client := redis.NewClient(clientConfig)
 	router := gin.Default()
-	router.GET("/translate/:word/to/:lang", func(gctx *gin.Context) {
+	router.POST("/auth/request", func(gctx *gin.Context) {
+		if !config.AuthEnabled {
+			gctx.AbortWithStatus(http.StatusNotFound)
+			return
+		}
+		pin := generatePIN()
+		client.Set(authPinKey(pin), "1", authPinTTL)
+		gctx.JSON(http.StatusOK, gin.H{"pin": pin, "expires_in": int(authPinTTL.Seconds())})
+	})
+	router.GET("/engines", func(gctx *gin.Context) {
+		gctx.JSON(http.StatusOK, engines)
+	})
+	router.GET("/languages", func(gctx *gin.Context) {
+		engine := strings.ToLower(strings.TrimSpace(gctx.DefaultQuery("engine", "google")))
+		entries, err := engineLanguages(client, engine)
+		if err != nil {
+			gctx.AbortWithStatus(http.StatusBadGateway)
+			return
+		}
+		gctx.JSON(http.StatusOK, entries)
+	})
+	router.GET("/languages/search", func(gctx *gin.Context) {
+		q := strings.ToLower(strings.TrimSpace(gctx.Query("q")))
+		if q == "" {
+			gctx.JSON(http.StatusOK, []languageMatch{})
+			return
+		}
+		engine := strings.ToLower(strings.TrimSpace(gctx.DefaultQuery("engine", "google")))
+		entries, err := engineLanguages(client, engine)
+		if err != nil {
+			gctx.AbortWithStatus(http.StatusBadGateway)
+			return
+		}
+		gctx.JSON(http.StatusOK, searchLanguages(entries, q))
+	})
+	router.POST("/subscribe", authMiddleware(client), func(gctx *gin.Context) {
+		var req subscribeRequest
+		if err := gctx.BindJSON(&req); err != nil {
+			gctx.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+		lang := strings.ToLower(strings.TrimSpace(req.Lang))
+		word := strings.ToLower(strings.TrimSpace(req.Word))
+		if lang == "" || word == "" {
+			gctx.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+		subscribeWatch(client, req.ChatID, lang, word)
+		gctx.Status(http.StatusOK)
+	})
+	translate := router.Group("/translate", authMiddleware(client))
+	translate.GET("/:word/to/:lang", func(gctx *gin.Context) {
 		word := strings.ToLower(strings.TrimSpace(gctx.Param("word")))
 		lang := strings.ToLower(strings.TrimSpace(gctx.Param("lang")))
 		if word == "" {
@@ -55,16 +130,33 @@ func main() {
 		cached := client.HGet(lang, word)
 		ans := cached.Val()
 		if cached.Err() != nil {
-			ans = fetch(word, lang, client)
+			ans, _ = fetch(word, lang, client)
 		}
 		gctx.String(http.StatusOK, ans)
 		return
 	})
+	translate.GET("/:word/from/:src/to/:lang", func(gctx *gin.Context) {
+		word := strings.ToLower(strings.TrimSpace(gctx.Param("word")))
+		src := strings.ToLower(strings.TrimSpace(gctx.Param("src")))
+		lang := strings.ToLower(strings.TrimSpace(gctx.Param("lang")))
+		if word == "" || lang == "" {
+			gctx.String(http.StatusOK, "")
+			return
+		}
+		if gctx.Query("engine") == "all" {
+			gctx.JSON(http.StatusOK, fetchAll(word, src, lang, client))
+			return
+		}
+		gctx.String(http.StatusOK, fetchFrom(word, src, lang, client))
+	})
 
-	notifyChannel = make(chan string)
-	go notificationLoop()
-	go func() { notifyChannel <- "import-lang backend started" }()
+	bot := initBot()
+	notifyChannel = make(chan notification)
+	go notificationLoop(bot)
+	go telegramCommandLoop(bot, client)
+	go func() { notify("import-lang backend started") }()
 	go cleanup(client)
+	go watchLoop(client)
 	go func() {
 		list, err := getEngines()
 		if err != nil {
@@ -77,12 +169,30 @@ func main() {
 	panic(router.Run(config.Listen))
 }
 
-func fetch(word, lang string, client *redis.Client) (string) {
+func fetch(word, lang string, client *redis.Client) (string, bool) {
+	for _, engine := range engines {
+		res := invokeTrans(word, "", lang, engine)
+		if res.Error == "" {
+			client.HSet(lang, word, res.Output)
+			cacheEngineResult(client, lang, "", engine, word, res.Output)
+			return res.Output, true
+		}
+	}
+	e := errors.New("failed to translate in all engines")
+	fmt.Println(e, word)
+	onTranslationError(word, lang, e)
+	return word, false
+}
+
+// fetchFrom caches its answer under the src-qualified engine key only; it
+// must not touch the bare lang hash, since that's what the source-agnostic
+// /translate/:word/to/:lang endpoint reads from.
+func fetchFrom(word, src, lang string, client *redis.Client) string {
 	for _, engine := range engines {
-		ans, err := invokeTrans(word, lang, engine)
-		if err == nil {
-			client.HSet(lang, word, ans)
-			return ans
+		res := invokeTrans(word, src, lang, engine)
+		if res.Error == "" {
+			cacheEngineResult(client, lang, src, engine, word, res.Output)
+			return res.Output
 		}
 	}
 	e := errors.New("failed to translate in all engines")
@@ -91,47 +201,368 @@ func fetch(word, lang string, client *redis.Client) (string) {
 	return word
 }
 
-func invokeTrans(word, lang, engine string) (string, error) {
+const maxConcurrentEngineCalls = 4
+
+func fetchAll(word, src, lang string, client *redis.Client) []EngineResult {
+	results := make([]EngineResult, len(engines))
+	sem := make(chan struct{}, maxConcurrentEngineCalls)
+	var wg sync.WaitGroup
+	for i, engine := range engines {
+		wg.Add(1)
+		go func(i int, engine string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			if cached, ok := cachedEngineResult(client, lang, src, engine, word); ok {
+				results[i] = EngineResult{Engine: engine, Output: cached}
+				return
+			}
+			res := invokeTrans(word, src, lang, engine)
+			if res.Error == "" {
+				cacheEngineResult(client, lang, src, engine, word, res.Output)
+			}
+			results[i] = res
+		}(i, engine)
+	}
+	wg.Wait()
+	return results
+}
+
+type EngineResult struct {
+	Engine         string `json:"engine"`
+	Output         string `json:"output"`
+	DetectedSource string `json:"detected_source,omitempty"`
+	LatencyMs      int64  `json:"latency_ms"`
+	Error          string `json:"error,omitempty"`
+}
+
+// src is part of the key so a specific source language never serves a cache
+// entry populated under a different (or absent/auto) source.
+func engineCacheKey(lang, src, engine string) string {
+	return fmt.Sprint(lang, ":", src, ":", engine)
+}
+
+func cacheEngineResult(client *redis.Client, lang, src, engine, word, output string) {
+	client.HSet(engineCacheKey(lang, src, engine), word, output)
+}
+
+func cachedEngineResult(client *redis.Client, lang, src, engine, word string) (string, bool) {
+	cached := client.HGet(engineCacheKey(lang, src, engine), word)
+	if cached.Err() != nil {
+		return "", false
+	}
+	return cached.Val(), true
+}
+
+type subscribeRequest struct {
+	ChatID int64  `json:"chat_id" binding:"required"`
+	Lang   string `json:"lang" binding:"required"`
+	Word   string `json:"word" binding:"required"`
+}
+
+const (
+	watchInitialInterval = 5 * time.Minute // re-check delay for a fresh watch
+	watchMaxInterval     = 6 * time.Hour   // backoff cap
+	watchPollInterval    = 30 * time.Second
+)
+
+func watchQueueKey() string {
+	return "watch:queue"
+}
+
+func watchIntervalKey() string {
+	return "watch:interval"
+}
+
+func watchMember(chatID int64, lang, word string) string {
+	return fmt.Sprint(chatID, "|", lang, "|", word)
+}
+
+func parseWatchMember(member string) (chatID int64, lang, word string, ok bool) {
+	parts := strings.SplitN(member, "|", 3)
+	if len(parts) != 3 {
+		return 0, "", "", false
+	}
+	chatID, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return 0, "", "", false
+	}
+	return chatID, parts[1], parts[2], true
+}
+
+func subscribeWatch(client *redis.Client, chatID int64, lang, word string) {
+	member := watchMember(chatID, lang, word)
+	client.HSet(watchIntervalKey(), member, fmt.Sprint(int64(watchInitialInterval.Seconds())))
+	client.ZAdd(watchQueueKey(), redis.Z{Score: float64(time.Now().Add(watchInitialInterval).Unix()), Member: member})
+}
+
+func unsubscribeWatch(client *redis.Client, chatID int64, lang, word string) {
+	member := watchMember(chatID, lang, word)
+	client.ZRem(watchQueueKey(), member)
+	client.HDel(watchIntervalKey(), member)
+}
+
+func listWatches(client *redis.Client, chatID int64) []string {
+	var result []string
+	for _, member := range client.ZRange(watchQueueKey(), 0, -1).Val() {
+		id, lang, word, ok := parseWatchMember(member)
+		if ok && id == chatID {
+			result = append(result, fmt.Sprint(lang, ": ", word))
+		}
+	}
+	return result
+}
+
+func watchLoop(client *redis.Client) {
+	ticker := time.NewTicker(watchPollInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		processDueWatches(client)
+	}
+}
+
+func processDueWatches(client *redis.Client) {
+	due := client.ZRangeByScore(watchQueueKey(), redis.ZRangeBy{
+		Min: "-inf",
+		Max: fmt.Sprint(time.Now().Unix()),
+	}).Val()
+	for _, member := range due {
+		chatID, lang, word, ok := parseWatchMember(member)
+		if !ok {
+			client.ZRem(watchQueueKey(), member)
+			continue
+		}
+		checkWatch(client, chatID, lang, word, member)
+	}
+}
+
+func checkWatch(client *redis.Client, chatID int64, lang, word, member string) {
+	before := client.HGet(lang, word).Val()
+	after, ok := fetch(word, lang, client)
+	if ok && after != before {
+		notifyChat(chatID, fmt.Sprint(word, " (", lang, "): ", before, " -> ", after))
+	}
+	rescheduleWatch(client, member)
+}
+
+func rescheduleWatch(client *redis.Client, member string) {
+	interval := watchInitialInterval
+	if secs, err := strconv.ParseInt(client.HGet(watchIntervalKey(), member).Val(), 10, 64); err == nil {
+		interval = time.Duration(secs) * time.Second * 2
+		if interval > watchMaxInterval {
+			interval = watchMaxInterval
+		}
+	}
+	client.HSet(watchIntervalKey(), member, fmt.Sprint(int64(interval.Seconds())))
+	client.ZAdd(watchQueueKey(), redis.Z{Score: float64(time.Now().Add(interval).Unix()), Member: member})
+}
+
+// src=="auto" runs trans in verbose mode to parse out a detected source;
+// anything else (including "") goes through brief mode as "src:lang".
+func invokeTrans(word, src, lang, engine string) EngineResult {
+	res := EngineResult{Engine: engine}
+	start := time.Now()
 	out := &bytes.Buffer{}
 	combined := &bytes.Buffer{}
-	cmd := exec.Command(config.Command, "-e", engine, "-b", ":"+lang, word)
+	auto := src == "auto"
+	var cmd *exec.Cmd
+	if auto {
+		cmd = exec.Command(config.Command, "-e", engine, lang, word)
+	} else {
+		cmd = exec.Command(config.Command, "-e", engine, "-b", src+":"+lang, word)
+	}
 	cmd.Stdout = io.MultiWriter(out, combined)
 	cmd.Stderr = combined
 	err := cmd.Run()
-	fmt.Println(engine, ":", string(combined.String()))
+	res.LatencyMs = time.Since(start).Milliseconds()
+	fmt.Println(engine, ":", combined.String())
 	if err != nil {
 		fmt.Println("failed to translate", word, ":", err)
+		res.Error = err.Error()
+		return res
+	}
+	if auto {
+		res.Output, res.DetectedSource = parseVerboseTrans(out.String())
+	} else {
+		res.Output = strings.ToLower(strings.TrimSpace(out.String()))
+	}
+	if res.Output == "" {
+		res.Error = "empty reply from API"
+	}
+	return res
+}
+
+// matches the "[src -> dst]" language pair in trans's verbose output
+var verboseLangRe = regexp.MustCompile(`\[([a-z]{2,3})\s*->\s*([a-z]{2,3})]`)
+
+func parseVerboseTrans(raw string) (output, detectedSource string) {
+	if m := verboseLangRe.FindStringSubmatch(raw); len(m) == 3 {
+		detectedSource = m[1]
+	}
+	for _, line := range strings.Split(raw, "\n") {
+		line = strings.ToLower(strings.TrimSpace(line))
+		if line != "" && !verboseLangRe.MatchString(line) {
+			return line, detectedSource
+		}
+	}
+	return "", detectedSource
+}
+
+const (
+	authPinTTL         = 2 * time.Minute
+	authMaxPinAttempts = 5
+	authLockoutWindow  = 10 * time.Minute
+)
+
+func authPinKey(pin string) string {
+	return fmt.Sprint("auth:pin:", pin)
+}
+
+func authTokenKey(token string) string {
+	return fmt.Sprint("auth:token:", token)
+}
+
+func authAttemptsKey(tgUserID int) string {
+	return fmt.Sprint("auth:attempts:", tgUserID)
+}
+
+func generatePIN() string {
+	n, err := rand.Int(rand.Reader, big.NewInt(1000000))
+	if err != nil {
+		panic(err)
+	}
+	return fmt.Sprintf("%06d", n.Int64())
+}
+
+func generateToken() (string, error) {
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
 		return "", err
 	}
-	ans := strings.ToLower(strings.TrimSpace(string(out.Bytes())))
-	if ans == "" {
-		return "", errors.New("empty reply from API")
+	return hex.EncodeToString(buf), nil
+}
+
+// repeated wrong PINs from the same telegram user lock them out for a while
+func handleAuthCommand(client *redis.Client, tgUserID int, pin string) string {
+	attemptsKey := authAttemptsKey(tgUserID)
+	attempts, err := client.Incr(attemptsKey).Result()
+	if err != nil {
+		fmt.Println("failed to check auth attempts:", err)
+		return "failed to issue token, try again"
+	}
+	if attempts == 1 {
+		client.Expire(attemptsKey, authLockoutWindow)
+	}
+	if attempts > authMaxPinAttempts {
+		return "too many failed attempts, try again later"
+	}
+	key := authPinKey(pin)
+	if client.Get(key).Err() != nil {
+		return "invalid or expired pin"
+	}
+	client.Del(key)
+	client.Del(attemptsKey)
+	token, err := generateToken()
+	if err != nil {
+		fmt.Println("failed to generate auth token:", err)
+		return "failed to issue token, try again"
+	}
+	client.Set(authTokenKey(token), fmt.Sprint(tgUserID), 0)
+	return fmt.Sprint("your token: ", token)
+}
+
+func handleWatchCommand(client *redis.Client, chatID int64, args string) string {
+	lang, word, ok := parseLangWordArgs(args)
+	if !ok {
+		return "usage: /watch <lang> <word>"
+	}
+	subscribeWatch(client, chatID, lang, word)
+	return fmt.Sprint("watching ", word, " (", lang, ") for changes")
+}
+
+func handleUnwatchCommand(client *redis.Client, chatID int64, args string) string {
+	lang, word, ok := parseLangWordArgs(args)
+	if !ok {
+		return "usage: /unwatch <lang> <word>"
+	}
+	unsubscribeWatch(client, chatID, lang, word)
+	return fmt.Sprint("stopped watching ", word, " (", lang, ")")
+}
+
+func handleWatchesCommand(client *redis.Client, chatID int64) string {
+	watches := listWatches(client, chatID)
+	if len(watches) == 0 {
+		return "no active watches"
+	}
+	return strings.Join(watches, "\n")
+}
+
+func parseLangWordArgs(args string) (lang, word string, ok bool) {
+	parts := strings.SplitN(strings.TrimSpace(args), " ", 2)
+	if len(parts) != 2 {
+		return "", "", false
+	}
+	lang = strings.ToLower(strings.TrimSpace(parts[0]))
+	word = strings.ToLower(strings.TrimSpace(parts[1]))
+	if lang == "" || word == "" {
+		return "", "", false
+	}
+	return lang, word, true
+}
+
+func authMiddleware(client *redis.Client) gin.HandlerFunc {
+	const prefix = "Bearer "
+	return func(gctx *gin.Context) {
+		if !config.AuthEnabled {
+			gctx.Next()
+			return
+		}
+		header := gctx.GetHeader("Authorization")
+		if !strings.HasPrefix(header, prefix) {
+			gctx.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+		token := strings.TrimPrefix(header, prefix)
+		if client.Get(authTokenKey(token)).Err() != nil {
+			gctx.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+		gctx.Next()
 	}
-	return ans, nil
 }
 
 func onTranslationError(originalWord, targetLanguage string, err error) {
 	fmt.Println("[error] ", originalWord, "(to", targetLanguage+")", err)
-	notifyChannel <- fmt.Sprint("[error] ", originalWord, " (to ", targetLanguage+") ", err)
+	notify(fmt.Sprint("[error] ", originalWord, " (to ", targetLanguage+") ", err))
 }
 
-func notificationLoop() {
-	var bot *tgbotapi.BotAPI
+// serializes bot.Send calls between the notification loop and the command loop
+var botSendMu sync.Mutex
+
+func initBot() *tgbotapi.BotAPI {
 	fmt.Println("initializing telegram bot...")
-	if bt, err := tgbotapi.NewBotAPI(config.BotToken); err != nil {
+	bot, err := tgbotapi.NewBotAPI(config.BotToken)
+	if err != nil {
 		fmt.Println("failed initialize telegram notifications:", err)
-	} else {
-		bot = bt
-		fmt.Println("telegram bot initialized")
+		return nil
 	}
+	fmt.Println("telegram bot initialized")
+	return bot
+}
+
+func notificationLoop(bot *tgbotapi.BotAPI) {
 	var batch []string
 	ticker := time.NewTicker(config.ThrottleNotification)
 	defer ticker.Stop()
 	for {
 		select {
-		case msg := <-notifyChannel:
-			fmt.Println(msg)
-			batch = append(batch, msg)
+		case n := <-notifyChannel:
+			fmt.Println(n.Message)
+			if n.ChatID != 0 {
+				sendTelegramMessage(bot, n.ChatID, n.Message)
+				continue
+			}
+			batch = append(batch, n.Message)
 		case <-ticker.C:
 			if len(batch) == 0 {
 				continue
@@ -145,7 +576,9 @@ func notificationLoop() {
 			fmt.Println("sending notification batch")
 			tmsg := tgbotapi.NewMessage(config.BotChatID, msg)
 			tmsg.DisableWebPagePreview = true
+			botSendMu.Lock()
 			_, err := bot.Send(tmsg)
+			botSendMu.Unlock()
 			if err != nil {
 				fmt.Println("failed send notification over telegram:", err)
 			} else {
@@ -157,6 +590,124 @@ func notificationLoop() {
 	}
 }
 
+func telegramCommandLoop(bot *tgbotapi.BotAPI, client *redis.Client) {
+	if bot == nil {
+		return
+	}
+	u := tgbotapi.NewUpdate(0)
+	u.Timeout = 60
+	updates, err := bot.GetUpdatesChan(u)
+	if err != nil {
+		fmt.Println("failed to start telegram updates channel:", err)
+		return
+	}
+	for update := range updates {
+		if update.Message == nil {
+			continue
+		}
+		handleTelegramMessage(bot, client, update.Message)
+	}
+}
+
+func handleTelegramMessage(bot *tgbotapi.BotAPI, client *redis.Client, msg *tgbotapi.Message) {
+	chatID := msg.Chat.ID
+	if !isAllowedTelegramUser(msg.From.ID) {
+		return
+	}
+	text := strings.TrimSpace(msg.Text)
+	switch {
+	case strings.HasPrefix(text, "/tr "):
+		sendTelegramMessage(bot, chatID, handleTranslateCommand(client, text[len("/tr "):]))
+	case text == "/engines":
+		sendTelegramMessage(bot, chatID, strings.Join(engines, ", "))
+	case text == "/lang" || strings.HasPrefix(text, "/lang "):
+		sendTelegramMessage(bot, chatID, handleLangCommand(client, chatID, strings.TrimSpace(text[len("/lang"):])))
+	case strings.HasPrefix(text, "/auth "):
+		sendTelegramMessage(bot, chatID, handleAuthCommand(client, msg.From.ID, strings.TrimSpace(text[len("/auth "):])))
+	case strings.HasPrefix(text, "/watch "):
+		sendTelegramMessage(bot, chatID, handleWatchCommand(client, chatID, strings.TrimSpace(text[len("/watch "):])))
+	case strings.HasPrefix(text, "/unwatch "):
+		sendTelegramMessage(bot, chatID, handleUnwatchCommand(client, chatID, strings.TrimSpace(text[len("/unwatch "):])))
+	case text == "/watches":
+		sendTelegramMessage(bot, chatID, handleWatchesCommand(client, chatID))
+	case text == "" || strings.HasPrefix(text, "/"):
+		// unknown command, ignore
+	default:
+		sendTelegramMessage(bot, chatID, handlePlainMessage(client, chatID, text))
+	}
+}
+
+func isAllowedTelegramUser(userID int) bool {
+	if len(config.TgAllowedUsers) == 0 {
+		return true
+	}
+	for _, id := range config.TgAllowedUsers {
+		if id == int64(userID) {
+			return true
+		}
+	}
+	return false
+}
+
+func handleTranslateCommand(client *redis.Client, args string) string {
+	parts := strings.SplitN(strings.TrimSpace(args), " ", 2)
+	if len(parts) != 2 {
+		return "usage: /tr <lang> <word>"
+	}
+	lang := strings.ToLower(strings.TrimSpace(parts[0]))
+	word := strings.ToLower(strings.TrimSpace(parts[1]))
+	if lang == "" || word == "" {
+		return "usage: /tr <lang> <word>"
+	}
+	ans, _ := fetch(word, lang, client)
+	return ans
+}
+
+func handleLangCommand(client *redis.Client, chatID int64, arg string) string {
+	if arg == "" {
+		if lang := chatLang(client, chatID); lang != "" {
+			return fmt.Sprint("current default language: ", lang)
+		}
+		return "usage: /lang <code>"
+	}
+	lang := strings.ToLower(strings.TrimSpace(arg))
+	setChatLang(client, chatID, lang)
+	return fmt.Sprint("default language set to ", lang)
+}
+
+func handlePlainMessage(client *redis.Client, chatID int64, text string) string {
+	lang := chatLang(client, chatID)
+	if lang == "" {
+		return "set a default language first with /lang <code>"
+	}
+	word := strings.ToLower(strings.TrimSpace(text))
+	ans, _ := fetch(word, lang, client)
+	return ans
+}
+
+func chatLangKey(chatID int64) string {
+	return fmt.Sprint("tg:chat:", chatID)
+}
+
+func chatLang(client *redis.Client, chatID int64) string {
+	return client.HGet(chatLangKey(chatID), "lang").Val()
+}
+
+func setChatLang(client *redis.Client, chatID int64, lang string) {
+	client.HSet(chatLangKey(chatID), "lang", lang)
+}
+
+func sendTelegramMessage(bot *tgbotapi.BotAPI, chatID int64, text string) {
+	if bot == nil || text == "" {
+		return
+	}
+	botSendMu.Lock()
+	defer botSendMu.Unlock()
+	if _, err := bot.Send(tgbotapi.NewMessage(chatID, text)); err != nil {
+		fmt.Println("failed to send telegram reply:", err)
+	}
+}
+
 func cleanup(client *redis.Client) {
 	removeEmptyTranslations(client)
 	removeNonPrintableTranslations(client)
@@ -183,7 +734,7 @@ func removeEmptyTranslations(client *redis.Client) {
 		go func() {
 			s := strings.Join(text, "\n")
 			fmt.Println(s)
-			notifyChannel <- s
+			notify(s)
 		}()
 	}
 }
@@ -213,17 +764,17 @@ func removeNonPrintableTranslations(client *redis.Client) {
 		go func() {
 			s := strings.Join(text, "\n")
 			fmt.Println(s)
-			notifyChannel <- s
+			notify(s)
 		}()
 	}
 }
 
 func infoNotification(message string) {
-	go func() { notifyChannel <- fmt.Sprint("[info] ", message) }()
+	go func() { notify(fmt.Sprint("[info] ", message)) }()
 }
 
 func errorNotification(message string) {
-	go func() { notifyChannel <- fmt.Sprint("[error] ", message) }()
+	go func() { notify(fmt.Sprint("[error] ", message)) }()
 }
 
 var textOnly = regexp.MustCompile("\\w+")
@@ -253,3 +804,127 @@ func getEngines() ([]string, error) {
 	}
 	return engines, nil
 }
+
+type languageEntry struct {
+	Code string `json:"code"`
+	Name string `json:"name"`
+}
+
+// lower Score is a better match
+type languageMatch struct {
+	Code  string `json:"code"`
+	Name  string `json:"name"`
+	Score int    `json:"score"`
+}
+
+func languagesCacheKey(engine string) string {
+	return fmt.Sprint("meta:languages:", engine)
+}
+
+func engineLanguages(client *redis.Client, engine string) ([]languageEntry, error) {
+	key := languagesCacheKey(engine)
+	if cached := client.Get(key); cached.Err() == nil {
+		var entries []languageEntry
+		if err := json.Unmarshal([]byte(cached.Val()), &entries); err == nil {
+			return entries, nil
+		}
+	}
+	entries, err := fetchEngineLanguages(engine)
+	if err != nil {
+		return nil, err
+	}
+	if data, err := json.Marshal(entries); err == nil {
+		client.Set(key, data, config.LanguagesCacheTTL)
+	}
+	return entries, nil
+}
+
+// pairs up `trans -T` codes with `trans -R` names by position
+func fetchEngineLanguages(engine string) ([]languageEntry, error) {
+	codes, err := runTransList(engine, "-T")
+	if err != nil {
+		return nil, err
+	}
+	names, err := runTransList(engine, "-R")
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]languageEntry, 0, len(codes))
+	for i, code := range codes {
+		name := code
+		if i < len(names) {
+			name = names[i]
+		}
+		entries = append(entries, languageEntry{Code: code, Name: name})
+	}
+	return entries, nil
+}
+
+func runTransList(engine, flag string) ([]string, error) {
+	cmd := exec.Command(config.Command, "-e", engine, flag)
+	data, err := cmd.CombinedOutput()
+	if err != nil {
+		return nil, err
+	}
+	var list []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" {
+			list = append(list, line)
+		}
+	}
+	return list, nil
+}
+
+// a substring hit wins outright, otherwise rank by Levenshtein distance
+func searchLanguages(entries []languageEntry, q string) []languageMatch {
+	matches := make([]languageMatch, 0, len(entries))
+	for _, e := range entries {
+		code := strings.ToLower(e.Code)
+		name := strings.ToLower(e.Name)
+		score := 0
+		if !strings.Contains(code, q) && !strings.Contains(name, q) {
+			score = levenshtein(q, name)
+			if c := levenshtein(q, code); c < score {
+				score = c
+			}
+		}
+		matches = append(matches, languageMatch{Code: e.Code, Name: e.Name, Score: score})
+	}
+	sort.Slice(matches, func(i, j int) bool { return matches[i].Score < matches[j].Score })
+	return matches
+}
+
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	if len(ra) == 0 {
+		return len(rb)
+	}
+	if len(rb) == 0 {
+		return len(ra)
+	}
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			min := prev[j] + 1
+			if v := cur[j-1] + 1; v < min {
+				min = v
+			}
+			if v := prev[j-1] + cost; v < min {
+				min = v
+			}
+			cur[j] = min
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(rb)]
+}